@@ -0,0 +1,58 @@
+package pgsql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBulkColumns(t *testing.T) {
+	items := []*sqlItem{
+		{ID: "u1", ETag: "e1", Payload: map[string]interface{}{"name": "bob"}},
+		{ID: "u2", ETag: "e2", Payload: map[string]interface{}{"name": "ann", "age": 30}},
+	}
+
+	got := bulkColumns(items)
+	want := []string{"id", "etag", "updated", "name", "age"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("bulkColumns() = %#v, want %#v", got, want)
+	}
+}
+
+func TestBulkValues(t *testing.T) {
+	i := &sqlItem{ID: "u1", ETag: "e1", Payload: map[string]interface{}{"name": "bob"}}
+	columns := []string{"id", "etag", "updated", "name"}
+
+	got := bulkValues(i, columns)
+	want := []interface{}{"u1", "e1", i.Updated, "bob"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("bulkValues() = %#v, want %#v", got, want)
+	}
+}
+
+func TestBulkValuesUpdatedFromPayloadOverridesField(t *testing.T) {
+	i := &sqlItem{ID: "u1", ETag: "e1", Payload: map[string]interface{}{"updated": "2020-01-01"}}
+	columns := []string{"id", "etag", "updated"}
+
+	got := bulkValues(i, columns)
+	want := []interface{}{"u1", "e1", "2020-01-01"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("bulkValues() = %#v, want %#v", got, want)
+	}
+}
+
+func TestBulkColumnsNoDuplicateUpdated(t *testing.T) {
+	items := []*sqlItem{
+		{ID: "u1", ETag: "e1", Payload: map[string]interface{}{"updated": "2020-01-01"}},
+	}
+
+	got := bulkColumns(items)
+	count := 0
+	for _, c := range got {
+		if c == "updated" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("bulkColumns() contains %d \"updated\" columns, want 1: %#v", count, got)
+	}
+}
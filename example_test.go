@@ -1,6 +1,7 @@
 package pgsql_test
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -102,15 +103,14 @@ func Example() {
 	}
 }
 func setupDB(db *sql.DB) {
-	var err error
-	// create users table
-	_, err = db.Exec("CREATE TABLE users (id character varying(128) NOT NULL, etag character varying(128), updated character varying(128), created character varying(128), name character varying(150), CONSTRAINT users_pkey PRIMARY KEY (id));")
-	if err != nil {
+	m := pgsql.NewMigrator(db)
+	if err := m.AddSchema(1, "users", user); err != nil {
 		log.Fatal(err)
 	}
-	// create posts table
-	_, err = db.Exec("CREATE TABLE posts (id character varying(128) NOT NULL, etag character varying(128), updated character varying(128), created character varying(128), \"user\" character varying(128), public integer title character varying(150), body character varying(100000), CONSTRAINT posts_pkey PRIMARY KEY (id), CONSTRAINT posts_user_fkey FOREIGN KEY (\"user\") REFERENCES users (id) MATCH SIMPLE ON UPDATE NO ACTION ON DELETE CASCADE);")
-	if err != nil {
+	if err := m.AddSchema(2, "posts", post); err != nil {
+		log.Fatal(err)
+	}
+	if err := m.Up(context.Background()); err != nil {
 		log.Fatal(err)
 	}
 }
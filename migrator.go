@@ -0,0 +1,256 @@
+package pgsql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/rs/rest-layer/schema"
+)
+
+// migrationsTable tracks which migrations a Migrator has already applied.
+const migrationsTable = "pgsql_migrations"
+
+// migration is a single numbered up/down SQL step.
+type migration struct {
+	version int64
+	up      string
+	down    string
+}
+
+// Migrator applies and tracks schema migrations for handler-managed tables.
+// It is modeled on goose: migrations are numbered SQL up/down steps, applied
+// inside a transaction, with progress recorded in a pgsql_migrations table.
+// Use AddSchema to derive a migration from a resource's schema.Schema, and
+// AddSQL for hand-written migrations; the two can be registered side by
+// side so generated schemas can keep evolving by hand afterwards.
+type Migrator struct {
+	db         *sql.DB
+	migrations []migration
+}
+
+// NewMigrator creates a Migrator that tracks its progress in db.
+func NewMigrator(db *sql.DB) *Migrator {
+	return &Migrator{db: db}
+}
+
+// AddSQL registers a hand-written migration at version.
+func (m *Migrator) AddSQL(version int64, up, down string) {
+	m.migrations = append(m.migrations, migration{version: version, up: up, down: down})
+}
+
+// AddSchema derives a CREATE TABLE migration for tableName from s and
+// registers it at version, with the matching DROP TABLE as its down step.
+func (m *Migrator) AddSchema(version int64, tableName string, s schema.Schema) error {
+	up, err := tableDDL(tableName, s)
+	if err != nil {
+		return err
+	}
+	down := fmt.Sprintf("DROP TABLE %s;", pq.QuoteIdentifier(tableName))
+	m.AddSQL(version, up, down)
+	return nil
+}
+
+// MigrationStatus reports a single registered migration's version and
+// whether it has been applied yet.
+type MigrationStatus struct {
+	Version int64
+	Applied bool
+}
+
+// Up applies every registered migration that hasn't run yet, in version
+// order, each inside its own transaction.
+func (m *Migrator) Up(ctx context.Context) error {
+	if err := m.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return err
+	}
+	for _, mig := range m.sortedByVersion() {
+		if applied[mig.version] {
+			continue
+		}
+		if err := m.apply(ctx, mig.version, mig.up, true); err != nil {
+			return fmt.Errorf("pgsql: migration %d up: %w", mig.version, err)
+		}
+	}
+	return nil
+}
+
+// Down rolls back the n most recently applied migrations, in reverse
+// version order, each inside its own transaction.
+func (m *Migrator) Down(ctx context.Context, n int) error {
+	if err := m.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return err
+	}
+	ms := m.sortedByVersion()
+	for i, j := 0, len(ms)-1; i < j; i, j = i+1, j-1 {
+		ms[i], ms[j] = ms[j], ms[i]
+	}
+	for _, mig := range ms {
+		if n <= 0 {
+			break
+		}
+		if !applied[mig.version] {
+			continue
+		}
+		if err := m.apply(ctx, mig.version, mig.down, false); err != nil {
+			return fmt.Errorf("pgsql: migration %d down: %w", mig.version, err)
+		}
+		n--
+	}
+	return nil
+}
+
+// Status reports every registered migration in version order, alongside
+// whether each has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	if err := m.ensureMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.sortedByVersion() {
+		out = append(out, MigrationStatus{Version: mig.version, Applied: applied[mig.version]})
+	}
+	return out, nil
+}
+
+func (m *Migrator) ensureMigrationsTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (version bigint PRIMARY KEY, applied_at timestamptz NOT NULL DEFAULT now());",
+		pq.QuoteIdentifier(migrationsTable)))
+	return err
+}
+
+func (m *Migrator) appliedVersions(ctx context.Context) (map[int64]bool, error) {
+	rows, err := m.db.QueryContext(ctx, fmt.Sprintf("SELECT version FROM %s", pq.QuoteIdentifier(migrationsTable)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]bool{}
+	for rows.Next() {
+		var v int64
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+func (m *Migrator) sortedByVersion() []migration {
+	ms := make([]migration, len(m.migrations))
+	copy(ms, m.migrations)
+	sort.Slice(ms, func(i, j int) bool { return ms[i].version < ms[j].version })
+	return ms
+}
+
+// apply runs step inside a transaction and records (or un-records, for a
+// down step) the migration's version against migrationsTable.
+func (m *Migrator) apply(ctx context.Context, version int64, step string, up bool) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, step); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if up {
+		_, err = tx.ExecContext(ctx,
+			fmt.Sprintf("INSERT INTO %s (version) VALUES ($1)", pq.QuoteIdentifier(migrationsTable)), version)
+	} else {
+		_, err = tx.ExecContext(ctx,
+			fmt.Sprintf("DELETE FROM %s WHERE version = $1", pq.QuoteIdentifier(migrationsTable)), version)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// tableDDL derives a CREATE TABLE statement for tableName from s. Every
+// table gets id/etag/updated/created columns and a primary key on id,
+// regardless of whether the schema declares them explicitly.
+func tableDDL(tableName string, s schema.Schema) (string, error) {
+	columns := []string{
+		pq.QuoteIdentifier("id") + " varchar(128) NOT NULL",
+		pq.QuoteIdentifier("etag") + " varchar(128)",
+		pq.QuoteIdentifier("updated") + " timestamptz",
+		pq.QuoteIdentifier("created") + " timestamptz",
+	}
+	seen := map[string]bool{"id": true, "etag": true, "updated": true, "created": true}
+	var fks []string
+
+	names := make([]string, 0, len(s.Fields))
+	for name := range s.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		col, fk, err := columnDDL(name, s.Fields[name])
+		if err != nil {
+			return "", err
+		}
+		columns = append(columns, col)
+		if fk != "" {
+			fks = append(fks, fk)
+		}
+	}
+
+	columns = append(columns, fmt.Sprintf("CONSTRAINT %s PRIMARY KEY (%s)",
+		pq.QuoteIdentifier(tableName+"_pkey"), pq.QuoteIdentifier("id")))
+	columns = append(columns, fks...)
+
+	return fmt.Sprintf("CREATE TABLE %s (%s);", pq.QuoteIdentifier(tableName), strings.Join(columns, ", ")), nil
+}
+
+// columnDDL maps a single schema.Field onto its column definition and, for a
+// schema.Reference, the foreign key constraint that enforces it.
+func columnDDL(name string, field schema.Field) (col string, fk string, err error) {
+	quoted := pq.QuoteIdentifier(name)
+	switch v := field.Validator.(type) {
+	case *schema.String:
+		if v.MaxLen > 0 {
+			col = fmt.Sprintf("%s varchar(%d)", quoted, v.MaxLen)
+		} else {
+			col = fmt.Sprintf("%s text", quoted)
+		}
+	case *schema.Integer:
+		col = fmt.Sprintf("%s bigint", quoted)
+	case *schema.Bool:
+		col = fmt.Sprintf("%s boolean", quoted)
+	case *schema.Time:
+		col = fmt.Sprintf("%s timestamptz", quoted)
+	case *schema.Reference:
+		col = fmt.Sprintf("%s varchar(128)", quoted)
+		fk = fmt.Sprintf("CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (id)",
+			pq.QuoteIdentifier(name+"_fkey"), quoted, pq.QuoteIdentifier(v.Path))
+	default:
+		return "", "", fmt.Errorf("pgsql: unsupported field type for column %q: %T", name, field.Validator)
+	}
+	if field.Required {
+		col += " NOT NULL"
+	}
+	return col, fk, nil
+}
@@ -0,0 +1,121 @@
+package pgsql
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rs/rest-layer/schema"
+)
+
+func TestColumnDDL(t *testing.T) {
+	cases := []struct {
+		name    string
+		field   schema.Field
+		wantCol string
+		wantFK  string
+	}{
+		{
+			name:    "string with max len",
+			field:   schema.Field{Validator: &schema.String{MaxLen: 150}},
+			wantCol: `"title" varchar(150)`,
+		},
+		{
+			name:    "string without max len falls back to text",
+			field:   schema.Field{Validator: &schema.String{}},
+			wantCol: `"title" text`,
+		},
+		{
+			name:    "required adds NOT NULL",
+			field:   schema.Field{Required: true, Validator: &schema.String{MaxLen: 10}},
+			wantCol: `"title" varchar(10) NOT NULL`,
+		},
+		{
+			name:    "integer maps to bigint",
+			field:   schema.Field{Validator: &schema.Integer{}},
+			wantCol: `"title" bigint`,
+		},
+		{
+			name:    "bool maps to boolean",
+			field:   schema.Field{Validator: &schema.Bool{}},
+			wantCol: `"title" boolean`,
+		},
+		{
+			name:    "time maps to timestamptz",
+			field:   schema.Field{Validator: &schema.Time{}},
+			wantCol: `"title" timestamptz`,
+		},
+		{
+			name:    "reference maps to a varchar column plus a foreign key",
+			field:   schema.Field{Validator: &schema.Reference{Path: "users"}},
+			wantCol: `"title" varchar(128)`,
+			wantFK:  `CONSTRAINT "title_fkey" FOREIGN KEY ("title") REFERENCES "users" (id)`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			col, fk, err := columnDDL("title", tc.field)
+			if err != nil {
+				t.Fatalf("columnDDL() error = %v", err)
+			}
+			if col != tc.wantCol {
+				t.Errorf("columnDDL() col = %q, want %q", col, tc.wantCol)
+			}
+			if fk != tc.wantFK {
+				t.Errorf("columnDDL() fk = %q, want %q", fk, tc.wantFK)
+			}
+		})
+	}
+}
+
+func TestColumnDDLUnsupportedValidator(t *testing.T) {
+	_, _, err := columnDDL("tags", schema.Field{Validator: &schema.Array{}})
+	if err == nil {
+		t.Fatal("columnDDL() error = nil, want an error for an unsupported validator")
+	}
+}
+
+func TestTableDDL(t *testing.T) {
+	s := schema.Schema{
+		Fields: schema.Fields{
+			"id":      schema.IDField,
+			"created": schema.CreatedField,
+			"updated": schema.UpdatedField,
+			"user": {
+				Required:  true,
+				Validator: &schema.Reference{Path: "users"},
+			},
+			"title": {
+				Required:  true,
+				Validator: &schema.String{MaxLen: 150},
+			},
+		},
+	}
+
+	got, err := tableDDL("posts", s)
+	if err != nil {
+		t.Fatalf("tableDDL() error = %v", err)
+	}
+
+	for _, want := range []string{
+		`CREATE TABLE "posts" (`,
+		`"id" varchar(128) NOT NULL`,
+		`"etag" varchar(128)`,
+		`"updated" timestamptz`,
+		`"created" timestamptz`,
+		`"title" varchar(150) NOT NULL`,
+		`"user" varchar(128) NOT NULL`,
+		`CONSTRAINT "posts_pkey" PRIMARY KEY ("id")`,
+		`CONSTRAINT "user_fkey" FOREIGN KEY ("user") REFERENCES "users" (id)`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("tableDDL() = %q, want it to contain %q", got, want)
+		}
+	}
+
+	// id/created/updated are schema fields too, but the always-present
+	// baseline columns must not be duplicated alongside them.
+	if n := strings.Count(got, `"updated" timestamptz`); n != 1 {
+		t.Errorf("tableDDL() contains %d \"updated\" columns, want 1: %q", n, got)
+	}
+}
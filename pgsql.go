@@ -8,24 +8,41 @@ import (
 	"fmt"
 	"strings"
 	"time"
-	//Must be annonymously imported so that we can not use its functions
-	_ "github.com/lib/pq"
+
+	"github.com/lib/pq"
 	"github.com/rs/rest-layer/resource"
 	"github.com/rs/rest-layer/schema/query"
 )
 
 // Handler contains the session and table information for a SQL DB.
 type Handler struct {
-	session   *sql.DB
-	tableName string
+	session             *sql.DB
+	tableName           string
+	bulkInsertThreshold int
+}
+
+// HandlerOption configures a Handler created with NewHandler.
+type HandlerOption func(*Handler)
+
+// WithBulkInsertThreshold makes Insert switch from one INSERT per item to a
+// single COPY FROM once the number of items being inserted reaches n. A
+// non-positive n (the default) disables the COPY path entirely.
+func WithBulkInsertThreshold(n int) HandlerOption {
+	return func(h *Handler) {
+		h.bulkInsertThreshold = n
+	}
 }
 
 // NewHandler creates an new SQL DB session handler.
-func NewHandler(s *sql.DB, tableName string) *Handler {
-	return &Handler{
+func NewHandler(s *sql.DB, tableName string, opts ...HandlerOption) *Handler {
+	h := &Handler{
 		session:   s,
 		tableName: tableName,
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 // sqlItem is a bson representation of a resource.Item
@@ -53,129 +70,149 @@ func newSQLItem(i *resource.Item) *sqlItem {
 	}
 }
 
-// Find is the SELECT query in normal SQL life
-func (h Handler) Find(ctx context.Context, q *query.Query) (*resource.ItemList, error) {
-	var err error
-	var rows *sql.Rows                // query result
-	var cols []string                 // column names
-	raw := []map[string]interface{}{} // holds the raw results as a map of columns:values
-
-	// execute the DB query, get the results
-	// build a paginated select statement based
-	qry, err := getSelect(h, q)
+// withTx runs fn inside a serializable, ctx-aware transaction: it begins the
+// transaction with BeginTx so ctx cancellation/deadlines abort in-flight
+// queries, commits if fn succeeds and rolls back otherwise.
+func (h Handler) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := h.session.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	// execute the DB query, get the results
-	rows, err = h.session.Query(qry)
-	if err != nil {
-		return nil, err
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
 	}
+	return tx.Commit()
+}
 
-	defer rows.Close()
+// Find is the SELECT query in normal SQL life
+func (h Handler) Find(ctx context.Context, q *query.Query) (*resource.ItemList, error) {
+	var list *resource.ItemList
+	err := h.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		var rows *sql.Rows                // query result
+		var cols []string                 // column names
+		raw := []map[string]interface{}{} // holds the raw results as a map of columns:values
+
+		// build a paginated select statement based on q, along with its bound args
+		qry, args, err := getSelect(h, q)
+		if err != nil {
+			return err
+		}
 
-	cols, err = rows.Columns()
-	if err != nil {
-		return nil, err
-	}
+		// execute the DB query, get the results
+		rows, err = tx.QueryContext(ctx, qry, args...)
+		if err != nil {
+			return err
+		}
 
-	for rows.Next() {
-		rowMap := make(map[string]interface{})       // col:val map for a row
-		rowVals := make([]interface{}, len(cols))    // values for a row
-		rowValPtrs := make([]interface{}, len(cols)) // pointers to row values used by Scan
+		defer rows.Close()
 
-		// create the pointers to the row value elements
-		for i := range cols {
-			rowValPtrs[i] = &rowVals[i]
+		cols, err = rows.Columns()
+		if err != nil {
+			return err
 		}
 
-		// scan into the pointer slice (and set the values)
-		err := rows.Scan(rowValPtrs...)
-		if err != nil {
-			return nil, err
+		// updated is scanned into a dedicated sql.NullTime rather than the
+		// generic interface{} used for the other columns, so it comes back
+		// as the time.Time the driver actually stored, not a re-stringified
+		// approximation of it.
+		updatedIdx := -1
+		for i, c := range cols {
+			if c == "updated" {
+				updatedIdx = i
+			}
 		}
 
-		// convert byte arrays to strings
-		for i, v := range rowVals {
-			b, ok := v.([]byte)
-			if ok {
-				v = string(b)
+		for rows.Next() {
+			rowMap := make(map[string]interface{})       // col:val map for a row
+			rowVals := make([]interface{}, len(cols))    // values for a row
+			rowValPtrs := make([]interface{}, len(cols)) // pointers to row values used by Scan
+			var updated sql.NullTime
+
+			// create the pointers to the row value elements
+			for i := range cols {
+				if i == updatedIdx {
+					rowValPtrs[i] = &updated
+				} else {
+					rowValPtrs[i] = &rowVals[i]
+				}
+			}
+
+			// scan into the pointer slice (and set the values)
+			err := rows.Scan(rowValPtrs...)
+			if err != nil {
+				return err
 			}
-			rowMap[cols[i]] = v
+
+			// convert byte arrays to strings
+			for i, v := range rowVals {
+				b, ok := v.([]byte)
+				if ok {
+					v = string(b)
+				}
+				rowMap[cols[i]] = v
+			}
+			if updatedIdx >= 0 {
+				rowMap["updated"] = updated.Time
+			}
+
+			// add the row to the intermediate data structure
+			raw = append(raw, rowMap)
 		}
 
-		// add the row to the intermediate data structure
-		raw = append(raw, rowMap)
-	}
+		// check for any errors during row iteration
+		if err := rows.Err(); err != nil {
+			return err
+		}
 
-	// check for any errors during row iteration
-	err = rows.Err()
+		// also check if Window is set at all otherwise use 0 as offset
+		var offset int
+		if q.Window != nil {
+			offset = q.Window.Offset
+		}
+		list, err = newItemList(raw, offset)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	// return a *resource.ItemList or an error
-	// also check if Window is set at all otherwise use 0 as offset
-	var offset int
-	if q.Window != nil {
-		offset = q.Window.Offset
-	}
-	return newItemList(raw, offset)
+	return list, nil
 }
 
 // Insert stores new items in the backend store. If any of the items already exist,
 // no item should be inserted and a resource.ErrConflict must be returned. The insertion
 // of the items is performed automatically.
-// TODO: add checking for resource.ErrConflict error.
 func (h *Handler) Insert(ctx context.Context, items []*resource.Item) error {
 	pItems := make([]*sqlItem, len(items))
 	for i, item := range items {
 		pItems[i] = newSQLItem(item)
 	}
 
-	err := insertItems(h, pItems)
-	if err != nil {
-		return err
-	}
-
-	if ctx.Err() != nil {
-		return ctx.Err()
-	}
-	return err
+	return h.withTx(ctx, func(tx *sql.Tx) error {
+		if h.bulkInsertThreshold > 0 && len(pItems) >= h.bulkInsertThreshold {
+			return bulkInsertItems(ctx, tx, h, pItems)
+		}
+		return insertItems(ctx, tx, h, pItems)
+	})
 }
 
 // Update replaces an item in the backend store with a new version. If the original
 // item is not found, a resource.ErrNotFound is returned. If the etags don't match, a
 // resource.ErrConflict is returned.
 func (h *Handler) Update(ctx context.Context, item *resource.Item, original *resource.Item) error {
+	return h.withTx(ctx, func(tx *sql.Tx) error {
+		if err := compareEtags(ctx, tx, h, original.ID, original.ETag); err != nil {
+			return err
+		}
 
-	// begin a database transaction
-	txPtr, err := h.session.Begin()
-	if err != nil {
-		return err
-	}
-
-	err = compareEtags(h, original.ID, original.ETag)
-	if err != nil {
-		txPtr.Rollback()
-		return err
-	}
-
-	s, err := getUpdate(h, item, original)
-	if err != nil {
-		txPtr.Rollback()
-		return err
-	}
-	_, err = h.session.Exec(s)
-	if err != nil {
-		txPtr.Rollback()
+		s, args, err := getUpdate(h, item, original)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, s, args...)
 		return err
-	}
-
-	// update succeeded, commit the transaction.
-	txPtr.Commit()
-	return nil
+	})
 }
 
 // Delete deletes the provided item by its ID. The Etag of the item stored in the
@@ -189,169 +226,213 @@ func (h *Handler) Update(ctx context.Context, item *resource.Item, original *res
 // on the passed ctx. If the operation is stopped due to context cancellation, the
 // function must return the result of the ctx.Err() method.
 func (h *Handler) Delete(ctx context.Context, item *resource.Item) error {
+	return h.withTx(ctx, func(tx *sql.Tx) error {
+		if err := compareEtags(ctx, tx, h, item.ID, item.ETag); err != nil {
+			return err
+		}
 
-	// begin a transaction
-	txPtr, err := h.session.Begin()
-	if err != nil {
-		return err
-	}
-
-	err = compareEtags(h, item.ID, item.ETag)
-	if err != nil {
-		txPtr.Rollback()
-		return err
-	}
-
-	// prepare and execute the delete statement, then finish the transaction
-	s := fmt.Sprintf("DELETE FROM %s WHERE id = '%s'", h.tableName, item.ID)
-	stmt, err := h.session.Prepare(s)
-	if err != nil {
-		txPtr.Rollback()
-		return err
-	}
-
-	_, err = stmt.Exec()
-	if err != nil {
-		txPtr.Rollback()
+		// prepare and execute the delete statement in the same tx as the etag check
+		s := fmt.Sprintf("DELETE FROM %s WHERE id = $1", pq.QuoteIdentifier(h.tableName))
+		_, err := tx.ExecContext(ctx, s, item.ID)
 		return err
-	}
-
-	txPtr.Commit()
-	return nil
+	})
 }
 
 // Clear removes all items matching the lookup and returns the number of items
 // removed as the first value.  If a query operation is not implemented
 // by the storage handler, a resource.ErrNotImplemented is returned.
 func (h Handler) Clear(ctx context.Context, q *query.Query) (int, error) {
-	// construct the delete statement from the lookup data
-	s, err := getDelete(h, q)
-	if err != nil {
-		return -1, err // should only be ErrNotImplemented
-	}
-	result, err := h.session.Exec(s)
+	var ra int64
+	err := h.withTx(ctx, func(tx *sql.Tx) error {
+		// construct the delete statement from the lookup data
+		s, args, err := getDelete(h, q)
+		if err != nil {
+			return err // should only be ErrNotImplemented
+		}
+		result, err := tx.ExecContext(ctx, s, args...)
+		if err != nil {
+			return err
+		}
+		ra, err = result.RowsAffected()
+		return err
+	})
 	if err != nil {
 		return -1, err
 	}
-	ra, err := result.RowsAffected()
-	if err != nil {
-		return -1, nil
-	}
 	return int(ra), nil
 }
 
-// insertItems inserts statements in Bulk
-func insertItems(h *Handler, items []*sqlItem) error {
-	//first we roll over the items
+// getInsert returns a parameterized SQL INSERT statement for i, along with
+// the args to bind against its placeholders. id is always the first bound
+// column, since the schema the Migrator generates has no default for it.
+func getInsert(h *Handler, i *sqlItem) (string, []interface{}) {
+	var columns bytes.Buffer
+	var placeholders bytes.Buffer
+	args := []interface{}{i.ID, i.ETag}
+	columns.WriteString(pq.QuoteIdentifier("id") + ", " + pq.QuoteIdentifier("etag") + ", ")
+	placeholders.WriteString("$1, $2, ")
+
+	for key, value := range i.Payload {
+		// now we prepare the columns and placeholders
+		columns.WriteString(pq.QuoteIdentifier(key) + ", ")
+		args = append(args, value)
+		placeholders.WriteString(fmt.Sprintf("$%d, ", len(args)))
+	}
+	// now we remove trailing commas
+	cString := columns.String()
+	cString = cString[:len(cString)-2] + ")"
+
+	pString := placeholders.String()
+	pString = pString[:len(pString)-2] + ")"
+
+	statement := fmt.Sprintf("INSERT INTO %s (%s VALUES (%s",
+		pq.QuoteIdentifier(h.tableName), cString, pString)
+	return statement, args
+}
+
+// insertItems inserts the items one at a time inside tx.
+func insertItems(ctx context.Context, tx *sql.Tx, h *Handler, items []*sqlItem) error {
 	for _, i := range items {
-		var statement bytes.Buffer
-		var columns bytes.Buffer
-		var rows bytes.Buffer
-		// second we create a transaction pointer to make sure all our is in safe environment
-		transactionPtr, err := h.session.Begin()
+		statement, args := getInsert(h, i)
+
+		// The caller already has the item's id, so there's nothing to scan
+		// back; a plain Exec also avoids assuming id's Go type, which
+		// schema.IDField makes a string.
+		_, err := tx.ExecContext(ctx, statement, args...)
 		if err != nil {
-			// if the transaction fails, return error
+			// now we check if the error is because of duplicate key
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return resource.ErrConflict
+			}
 			return err
 		}
-		//defer closing the connection
-		defer h.session.Close()
-
-		// third we prepare the statement
-		statement.WriteString("INSERT INTO " + h.tableName)
-		columns.WriteString("(etag, ")
-		rows.WriteString("VALUES('" + i.ETag + "', ")
-
-		for key, value := range i.Payload {
-			//now we prepare the columns and rows
-			columns.WriteString(key + ", ")
-			v, err := valueToString(value)
-			if err != nil {
-				return err
+	}
+	return nil
+}
+
+// bulkColumns computes the ordered set of columns a COPY of items needs: the
+// always-present id/etag/updated columns followed by the union of every
+// item's payload keys, in the order items first introduce them. Keys first
+// introduced by the same item follow Go's map iteration order, so their
+// relative order is not defined.
+func bulkColumns(items []*sqlItem) []string {
+	seen := map[string]bool{"id": true, "etag": true, "updated": true}
+	columns := []string{"id", "etag", "updated"}
+	for _, i := range items {
+		for key := range i.Payload {
+			if !seen[key] {
+				seen[key] = true
+				columns = append(columns, key)
 			}
-			rows.WriteString(v + ", ")
 		}
-		//now we remove trailing commas
-		cString := columns.String()
-		cString = cString[:len(cString)-2] + ")"
-		var fColumns bytes.Buffer
-		fColumns.WriteString(cString)
-
-		rString := rows.String()
-		rString = rString[:len(rString)-2] + ") RETURNING id"
-		var fRows bytes.Buffer
-		fRows.WriteString(rString)
-
-		statement.WriteString(fColumns.String())
-		statement.WriteString(fRows.String())
-
-		// all good now commit!
-		query := statement.String()
-		var ID int
-		err = transactionPtr.QueryRow(query).Scan(&ID)
-		if err != nil {
-			//now we check if the error is because of duplicate key
-			errorString := fmt.Sprintln(err)
-			if strings.Contains(errorString, "pq: duplicate key value violates unique constraint") {
-				// duplicate key error
-				transactionPtr.Rollback()
-				return resource.ErrConflict
+	}
+	return columns
+}
+
+// bulkValues returns i's values in columns order, ready to pass to a
+// pq.CopyIn statement built from the same columns list.
+func bulkValues(i *sqlItem, columns []string) []interface{} {
+	vals := make([]interface{}, len(columns))
+	for idx, c := range columns {
+		switch c {
+		case "id":
+			vals[idx] = i.ID
+		case "etag":
+			vals[idx] = i.ETag
+		case "updated":
+			if v, ok := i.Payload["updated"]; ok {
+				vals[idx] = v
+			} else {
+				vals[idx] = i.Updated
 			}
-			transactionPtr.Rollback()
-			return err
-		}
-		// all good! commit the query
-		err = transactionPtr.Commit()
-		if err != nil {
-			return err
+		default:
+			vals[idx] = i.Payload[c]
 		}
 	}
-	return nil
+	return vals
 }
 
-// getUpdate returns a SQL INSERT statement constructed from the Item data
-func getUpdate(h *Handler, i *resource.Item, o *resource.Item) (string, error) {
-	var id string
-	var err error
+// bulkInsertItems streams items into h.tableName using COPY FROM, which is
+// far fewer round-trips than one INSERT per item. It runs under a savepoint
+// so that a unique-violation (which COPY reports for the batch as a whole,
+// not per offending row) can be rolled back and retried through the per-row
+// insertItems path, preserving the items' order and resource.ErrConflict
+// semantics.
+func bulkInsertItems(ctx context.Context, tx *sql.Tx, h *Handler, items []*sqlItem) error {
+	const savepoint = "pgsql_bulk_insert"
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+savepoint); err != nil {
+		return err
+	}
 
-	id, err = valueToString(o.ID)
+	columns := bulkColumns(items)
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(h.tableName, columns...))
 	if err != nil {
-		return "", resource.ErrNotImplemented
+		return err
 	}
 
-	a := fmt.Sprintf("UPDATE %s SET etag=%s,", h.tableName, "'"+i.ETag+"'")
-	z := fmt.Sprintf("WHERE id=%s AND etag=%s;", id, "'"+o.ETag+"'")
-	for k, v := range i.Payload {
-		if k != "id" {
-			var val string
-			val, err = valueToString(v)
-			if err != nil {
-				return "", resource.ErrNotImplemented
-			}
-			//another cheap hack of the cheapest hacks ever hacked in the history of cheapness
-			//but seriously why is time.Time type returns this incompatible format?
-			//example: 2018-02-27 23:07:44.4179416 +0100 CET m=+7.679574500
-			//the m=+7.679574500 appears from nowhere and is unparsable or formattable
-			//TODO: FIXME!
-			if k == "updated" {
-				val = "'" + time.Now().Format(time.RFC3339) + "'"
+	fallback := func(copyErr error) error {
+		stmt.Close()
+		if pqErr, ok := copyErr.(*pq.Error); ok && pqErr.Code == "23505" {
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+savepoint); err != nil {
+				return err
 			}
-			a += fmt.Sprintf("%s=%s,", k, val)
+			return insertItems(ctx, tx, h, items)
 		}
+		return copyErr
+	}
+
+	for _, i := range items {
+		if _, err := stmt.Exec(bulkValues(i, columns)...); err != nil {
+			return fallback(err)
+		}
+	}
 
+	// the empty Exec flushes the buffered rows to the server
+	if _, err := stmt.Exec(); err != nil {
+		return fallback(err)
+	}
+	if err := stmt.Close(); err != nil {
+		return err
 	}
-	// remove trailing comma
-	a = a[:len(a)-1]
 
-	result := fmt.Sprintf("%s %s", a, z)
-	return result, nil
+	_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT "+savepoint)
+	return err
 }
 
-// getSelect returns a SQL SELECT statement that represents the Lookup data
-func getSelect(h Handler, q *query.Query) (string, error) {
-	str := "SELECT * FROM " + h.tableName
-	qry, err := getQuery(q)
+// getUpdate returns a parameterized SQL UPDATE statement constructed from the
+// Item data, along with the args to bind against its placeholders.
+func getUpdate(h *Handler, i *resource.Item, o *resource.Item) (string, []interface{}, error) {
+	args := []interface{}{i.ETag}
+	setClauses := []string{fmt.Sprintf("%s=$1", pq.QuoteIdentifier("etag"))}
+
+	for k, v := range i.Payload {
+		if k == "id" {
+			continue
+		}
+		// v is bound directly, so a time.Time value here (e.g. "updated")
+		// is sent to the driver as-is and stored as a timestamptz.
+		args = append(args, v)
+		setClauses = append(setClauses, fmt.Sprintf("%s=$%d", pq.QuoteIdentifier(k), len(args)))
+	}
+
+	args = append(args, o.ID)
+	idPlaceholder := fmt.Sprintf("$%d", len(args))
+	args = append(args, o.ETag)
+	etagPlaceholder := fmt.Sprintf("$%d", len(args))
+
+	result := fmt.Sprintf("UPDATE %s SET %s WHERE id=%s AND etag=%s;",
+		pq.QuoteIdentifier(h.tableName), strings.Join(setClauses, ","), idPlaceholder, etagPlaceholder)
+	return result, args, nil
+}
+
+// getSelect returns a parameterized SQL SELECT statement that represents the
+// Lookup data, along with the args to bind against its placeholders.
+func getSelect(h Handler, q *query.Query) (string, []interface{}, error) {
+	str := "SELECT * FROM " + pq.QuoteIdentifier(h.tableName)
+	args := []interface{}{}
+	qry, err := getQuery(q, &args)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 	if qry != "" {
 		str += " WHERE " + qry
@@ -364,18 +445,20 @@ func getSelect(h Handler, q *query.Query) (string, error) {
 		str += fmt.Sprintf(" OFFSET %d", q.Window.Offset)
 	}
 	str += ";"
-	return str, nil
+	return str, args, nil
 }
 
-// getDelete returns a SQL DELETE statement that represents the Lookup data
-func getDelete(h Handler, q *query.Query) (string, error) {
-	str := "DELETE FROM " + h.tableName + " WHERE "
-	qry, err := getQuery(q)
+// getDelete returns a parameterized SQL DELETE statement that represents the
+// Lookup data, along with the args to bind against its placeholders.
+func getDelete(h Handler, q *query.Query) (string, []interface{}, error) {
+	str := "DELETE FROM " + pq.QuoteIdentifier(h.tableName) + " WHERE "
+	args := []interface{}{}
+	qry, err := getQuery(q, &args)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 	str += qry + ";"
-	return str, nil
+	return str, args, nil
 }
 
 // newItemList creates a list of resource.Item from a SQL result row slice
@@ -398,35 +481,31 @@ func newItem(row map[string]interface{}) (*resource.Item, error) {
 	// Add the id back (we use the same map hoping the sqlItem won't be stored back)
 	id := row["id"]
 	etag := row["etag"]
+	updated, _ := row["updated"].(time.Time)
 	delete(row, "etag")
 	delete(row, "updated")
 
-	tu, err := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	if err != nil {
-		return nil, err
-	}
 	return &resource.Item{
 		ID:      id,
 		ETag:    etag.(string),
-		Updated: tu,
+		Updated: updated,
 		Payload: row,
 	}, nil
 }
 
-func compareEtags(h *Handler, id, origEtag interface{}) error {
-	// query for record with the same id, and return ErrNotFound if we don't find one.
+// compareEtags checks, inside tx, that the stored etag for id still matches
+// origEtag, returning resource.ErrNotFound if no such row exists and
+// resource.ErrConflict if the etags have diverged. Running the check inside
+// the same tx as the mutation it guards makes the compare-and-swap atomic.
+func compareEtags(ctx context.Context, tx *sql.Tx, h *Handler, id, origEtag interface{}) error {
 	var etag string
-	//create a pointer
-	transactionPtr, err := h.session.Begin()
-	if err != nil {
-		return err
-	}
 
-	transactionPtr.QueryRow("SELECT etag FROM $1 WHERE id='$2'", h.tableName, id).Scan(&etag)
-
-	err = transactionPtr.Commit()
+	s := fmt.Sprintf("SELECT etag FROM %s WHERE id=$1", pq.QuoteIdentifier(h.tableName))
+	err := tx.QueryRowContext(ctx, s, id).Scan(&etag)
+	if err == sql.ErrNoRows {
+		return resource.ErrNotFound
+	}
 	if err != nil {
-		transactionPtr.Rollback()
 		return err
 	}
 
@@ -0,0 +1,88 @@
+package pgsql
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rs/rest-layer/resource"
+	"github.com/rs/rest-layer/schema/query"
+)
+
+func TestGetSelect(t *testing.T) {
+	h := Handler{tableName: "users"}
+	q := &query.Query{
+		Predicate: query.Predicate{query.Equal{Field: "age", Value: 18}},
+		Sort:      query.Sort{{Name: "name"}},
+		Window:    &query.Window{Offset: 10, Limit: 5},
+	}
+
+	got, args, err := getSelect(h, q)
+	if err != nil {
+		t.Fatalf("getSelect() error = %v", err)
+	}
+	want := `SELECT * FROM "users" WHERE "age" = $1 ORDER BY "name" LIMIT 5 OFFSET 10;`
+	if got != want {
+		t.Errorf("getSelect() = %q, want %q", got, want)
+	}
+	if wantArgs := []interface{}{18}; !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("getSelect() args = %#v, want %#v", args, wantArgs)
+	}
+}
+
+func TestGetDelete(t *testing.T) {
+	h := Handler{tableName: "users"}
+	q := &query.Query{Predicate: query.Predicate{query.Equal{Field: "id", Value: "u1"}}}
+
+	got, args, err := getDelete(h, q)
+	if err != nil {
+		t.Fatalf("getDelete() error = %v", err)
+	}
+	want := `DELETE FROM "users" WHERE "id" LIKE $1 ESCAPE '\';`
+	if got != want {
+		t.Errorf("getDelete() = %q, want %q", got, want)
+	}
+	if wantArgs := []interface{}{"u1"}; !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("getDelete() args = %#v, want %#v", args, wantArgs)
+	}
+}
+
+func TestGetInsert(t *testing.T) {
+	h := &Handler{tableName: "users"}
+	i := &sqlItem{ID: "u1", ETag: "e1", Payload: map[string]interface{}{"name": "bob"}}
+
+	got, args := getInsert(h, i)
+	want := `INSERT INTO "users" ("id", "etag", "name") VALUES ($1, $2, $3)`
+	if got != want {
+		t.Errorf("getInsert() = %q, want %q", got, want)
+	}
+	// id must be the first bound column: the schema the Migrator generates
+	// has no default for it, so every insert must supply it explicitly.
+	wantArgs := []interface{}{"u1", "e1", "bob"}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("getInsert() args = %#v, want %#v", args, wantArgs)
+	}
+}
+
+func TestGetUpdate(t *testing.T) {
+	h := &Handler{tableName: "users"}
+	item := &resource.Item{
+		ETag:    "new-etag",
+		Payload: map[string]interface{}{"id": "u1", "name": "bob"},
+	}
+	original := &resource.Item{ID: "u1", ETag: "old-etag"}
+
+	got, args, err := getUpdate(h, item, original)
+	if err != nil {
+		t.Fatalf("getUpdate() error = %v", err)
+	}
+	want := `UPDATE "users" SET "etag"=$1,"name"=$2 WHERE id=$3 AND etag=$4;`
+	if got != want {
+		t.Errorf("getUpdate() = %q, want %q", got, want)
+	}
+	// id's payload entry must be skipped entirely, and the placeholders for
+	// the original row's id/etag must come last, in that order.
+	wantArgs := []interface{}{"new-etag", "bob", "u1", "old-etag"}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("getUpdate() args = %#v, want %#v", args, wantArgs)
+	}
+}
@@ -3,15 +3,18 @@ package pgsql
 import (
 	"fmt"
 	"strings"
-	"time"
 
+	"github.com/lib/pq"
 	"github.com/rs/rest-layer/resource"
 	"github.com/rs/rest-layer/schema/query"
 )
 
-// getQuery transform a query into an SQL compliant query
-func getQuery(q *query.Query) (string, error) {
-	return translatePredicate(q.Predicate)
+// getQuery transforms a query into a parameterized SQL WHERE fragment. Values
+// referenced by the predicate are appended to args and replaced in the
+// fragment by their matching $N placeholders; nil and bool Equal/NotEqual
+// values are the exception and are written as NULL/TRUE/FALSE literals.
+func getQuery(q *query.Query, args *[]interface{}) (string, error) {
+	return translatePredicate(q.Predicate, args)
 }
 
 // getSort transform a query.Query into an SQL compliant SORT.
@@ -20,27 +23,26 @@ func getSort(q *query.Query) string {
 	l := q.Sort
 	var str string
 	if len(l) == 0 {
-		return "id"
+		return pq.QuoteIdentifier("id")
 	}
 	for _, sort := range q.Sort {
+		str += pq.QuoteIdentifier(sort.Name)
 		if sort.Reversed {
-			str += sort.Name + " DESC"
-		} else {
-			str += sort.Name
+			str += " DESC"
 		}
 		str += ","
 	}
 	return str[:len(str)-1]
 }
 
-func translatePredicate(p query.Predicate) (string, error) {
+func translatePredicate(p query.Predicate, args *[]interface{}) (string, error) {
 	var str string
 	for _, exp := range p {
 		switch t := exp.(type) {
 		case query.And:
 			var s string
 			for _, subExp := range t {
-				sb, err := translatePredicate(query.Predicate{subExp})
+				sb, err := translatePredicate(query.Predicate{subExp}, args)
 				if err != nil {
 					return "", err
 				}
@@ -51,7 +53,7 @@ func translatePredicate(p query.Predicate) (string, error) {
 		case query.Or:
 			var s string
 			for _, subExp := range t {
-				sb, err := translatePredicate(query.Predicate{subExp})
+				sb, err := translatePredicate(query.Predicate{subExp}, args)
 				if err != nil {
 					return "", err
 				}
@@ -60,67 +62,53 @@ func translatePredicate(p query.Predicate) (string, error) {
 			// remove the last " OR "
 			str += "(" + s[:len(s)-4] + ")"
 		case query.In:
-			v, err := valuesToString(t.Values)
+			v, err := valuesToString(args, t.Values)
 			if err != nil {
 				return "", resource.ErrNotImplemented
 			}
-			str += t.Field + " IN (" + v + ")"
+			str += pq.QuoteIdentifier(t.Field) + " IN (" + v + ")"
 		case query.NotIn:
-			v, err := valuesToString(t.Values)
+			v, err := valuesToString(args, t.Values)
 			if err != nil {
 				return "", resource.ErrNotImplemented
 			}
-			str += t.Field + " NOT IN (" + v + ")"
+			str += pq.QuoteIdentifier(t.Field) + " NOT IN (" + v + ")"
 		case query.Equal:
-			v, err := valueToString(t.Value)
+			s, err := equalityExpr(args, t.Field, t.Value, false)
 			if err != nil {
-				return "", resource.ErrNotImplemented
-			}
-			switch t.Value.(type) {
-			case string:
-				v = strings.Replace(v, "*", "%", -1)
-				v = strings.Replace(v, "_", "\\_", -1)
-				str += t.Field + " LIKE " + v + " ESCAPE '\\'"
-			default:
-				str += t.Field + " IS " + v
+				return "", err
 			}
+			str += s
 		case query.NotEqual:
-			v, err := valueToString(t.Value)
+			s, err := equalityExpr(args, t.Field, t.Value, true)
 			if err != nil {
-				return "", resource.ErrNotImplemented
-			}
-			switch t.Value.(type) {
-			case string:
-				v = strings.Replace(v, "*", "%", -1)
-				v = strings.Replace(v, "_", "\\_", -1)
-				str += t.Field + " NOT LIKE " + v + " ESCAPE '\\'"
-			default:
-				str += t.Field + " IS NOT " + v
+				return "", err
 			}
+			str += s
 		case query.GreaterThan:
-			v, err := valueToString(t.Value)
+			v, err := valueToString(args, t.Value)
 			if err != nil {
 				return "", resource.ErrNotImplemented
 			}
-			str += t.Field + " > " + v
+			str += pq.QuoteIdentifier(t.Field) + " > " + v
 		case query.GreaterOrEqual:
-			v, err := valueToString(t.Value)
+			v, err := valueToString(args, t.Value)
 			if err != nil {
 				return "", resource.ErrNotImplemented
 			}
-			str += t.Field + " >= " + v
+			str += pq.QuoteIdentifier(t.Field) + " >= " + v
 		case query.LowerThan:
-			v, err := valueToString(t.Value)
+			v, err := valueToString(args, t.Value)
 			if err != nil {
 				return "", resource.ErrNotImplemented
 			}
-			str += t.Field + " < " + v
+			str += pq.QuoteIdentifier(t.Field) + " < " + v
 		case query.LowerOrEqual:
-			v, err := valueToString(t.Value)
+			v, err := valueToString(args, t.Value)
 			if err != nil {
 				return "", resource.ErrNotImplemented
 			}
-			str += t.Field + " <= " + v
+			str += pq.QuoteIdentifier(t.Field) + " <= " + v
 		default:
 			return "", resource.ErrNotImplemented
 		}
@@ -128,38 +116,80 @@ func translatePredicate(p query.Predicate) (string, error) {
 	return str, nil
 }
 
-// valuesToString combines a list of Values into a single comma separated string
-func valuesToString(v []query.Value) (string, error) {
-	var str string
-	for _, v := range v {
-		s, err := valueToString(v)
+// equalityExpr builds the SQL fragment for a query.Equal/query.NotEqual
+// comparison against value. PostgreSQL's IS/IS NOT operator only accepts the
+// literal keywords NULL/TRUE/FALSE/UNKNOWN, so nil and bool values are
+// rendered as those literals rather than bound parameters; every other
+// value is bound and compared with =/<>, and strings keep the existing
+// LIKE/NOT LIKE glob matching.
+func equalityExpr(args *[]interface{}, field string, value query.Value, negate bool) (string, error) {
+	f := pq.QuoteIdentifier(field)
+	switch v := value.(type) {
+	case string:
+		ph, err := valueToString(args, likeValue(v))
 		if err != nil {
-			return "", err
+			return "", resource.ErrNotImplemented
+		}
+		if negate {
+			return f + " NOT LIKE " + ph + " ESCAPE '\\'", nil
+		}
+		return f + " LIKE " + ph + " ESCAPE '\\'", nil
+	case nil:
+		if negate {
+			return f + " IS NOT NULL", nil
+		}
+		return f + " IS NULL", nil
+	case bool:
+		lit := "FALSE"
+		if v {
+			lit = "TRUE"
+		}
+		if negate {
+			return f + " IS NOT " + lit, nil
+		}
+		return f + " IS " + lit, nil
+	default:
+		ph, err := valueToString(args, value)
+		if err != nil {
+			return "", resource.ErrNotImplemented
 		}
-		str += fmt.Sprintf("%s,", s)
+		if negate {
+			return f + " <> " + ph, nil
+		}
+		return f + " = " + ph, nil
 	}
-	return str[:len(str)-1], nil
 }
 
-// valueToString converts a Value into a type-specific string
-func valueToString(v query.Value) (string, error) {
-	var str string
-	var i interface{} = v
+// likeValue maps the glob-style wildcards accepted by query.Equal/query.NotEqual
+// string matches onto their SQL LIKE equivalents. The mapping is applied to the
+// raw value before it is bound, so the bound parameter is valid LIKE pattern.
+func likeValue(v query.Value) query.Value {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	s = strings.Replace(s, "*", "%", -1)
+	s = strings.Replace(s, "_", "\\_", -1)
+	return s
+}
 
-	switch i.(type) {
-	case int:
-		str += fmt.Sprintf("%v", i)
-	case float64:
-		str += fmt.Sprintf("%v", i)
-	case bool:
-		str += fmt.Sprintf("%v", i)
-	case string:
-		str += fmt.Sprintf("'%v'", i)
-	case time.Time:
-		str += fmt.Sprintf("'%v'", i)
-	default:
-		str += fmt.Sprintf("'%v'", i)
-		//return "", resource.ErrNotImplemented
+// valuesToString binds a list of Values as query parameters and returns the
+// comma separated placeholders to inline in the query text in their place.
+func valuesToString(args *[]interface{}, v []query.Value) (string, error) {
+	parts := make([]string, 0, len(v))
+	for _, val := range v {
+		s, err := valueToString(args, val)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, s)
 	}
-	return str, nil
+	return strings.Join(parts, ","), nil
+}
+
+// valueToString appends v to args and returns the $N placeholder that stands
+// in for it in the query text, so callers never interpolate values directly.
+func valueToString(args *[]interface{}, v query.Value) (string, error) {
+	*args = append(*args, v)
+	return fmt.Sprintf("$%d", len(*args)), nil
 }
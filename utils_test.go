@@ -0,0 +1,158 @@
+package pgsql
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rs/rest-layer/schema/query"
+)
+
+func TestTranslatePredicate(t *testing.T) {
+	cases := []struct {
+		name     string
+		pred     query.Predicate
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "equal string uses LIKE with bound glob",
+			pred:     query.Predicate{query.Equal{Field: "name", Value: "f*o"}},
+			wantSQL:  `"name" LIKE $1 ESCAPE '\'`,
+			wantArgs: []interface{}{"f%o"},
+		},
+		{
+			name:     "not equal string uses NOT LIKE with bound glob",
+			pred:     query.Predicate{query.NotEqual{Field: "name", Value: "f*o"}},
+			wantSQL:  `"name" NOT LIKE $1 ESCAPE '\'`,
+			wantArgs: []interface{}{"f%o"},
+		},
+		{
+			name:     "equal nil renders IS NULL literal, no bound arg",
+			pred:     query.Predicate{query.Equal{Field: "deleted_at", Value: nil}},
+			wantSQL:  `"deleted_at" IS NULL`,
+			wantArgs: []interface{}{},
+		},
+		{
+			name:     "not equal nil renders IS NOT NULL literal, no bound arg",
+			pred:     query.Predicate{query.NotEqual{Field: "deleted_at", Value: nil}},
+			wantSQL:  `"deleted_at" IS NOT NULL`,
+			wantArgs: []interface{}{},
+		},
+		{
+			name:     "equal true renders IS TRUE literal, no bound arg",
+			pred:     query.Predicate{query.Equal{Field: "public", Value: true}},
+			wantSQL:  `"public" IS TRUE`,
+			wantArgs: []interface{}{},
+		},
+		{
+			name:     "equal false renders IS FALSE literal, no bound arg",
+			pred:     query.Predicate{query.Equal{Field: "public", Value: false}},
+			wantSQL:  `"public" IS FALSE`,
+			wantArgs: []interface{}{},
+		},
+		{
+			name:     "not equal true renders IS NOT TRUE literal, no bound arg",
+			pred:     query.Predicate{query.NotEqual{Field: "public", Value: true}},
+			wantSQL:  `"public" IS NOT TRUE`,
+			wantArgs: []interface{}{},
+		},
+		{
+			name:     "equal int binds with =",
+			pred:     query.Predicate{query.Equal{Field: "age", Value: 42}},
+			wantSQL:  `"age" = $1`,
+			wantArgs: []interface{}{42},
+		},
+		{
+			name:     "not equal int binds with <>",
+			pred:     query.Predicate{query.NotEqual{Field: "age", Value: 42}},
+			wantSQL:  `"age" <> $1`,
+			wantArgs: []interface{}{42},
+		},
+		{
+			name:     "greater than binds a single placeholder",
+			pred:     query.Predicate{query.GreaterThan{Field: "age", Value: 18}},
+			wantSQL:  `"age" > $1`,
+			wantArgs: []interface{}{18},
+		},
+		{
+			name:     "in binds one placeholder per value",
+			pred:     query.Predicate{query.In{Field: "id", Values: []query.Value{"a", "b"}}},
+			wantSQL:  `"id" IN ($1,$2)`,
+			wantArgs: []interface{}{"a", "b"},
+		},
+		{
+			name: "and shares a single, continuously numbered args slice",
+			pred: query.Predicate{query.And{
+				query.Equal{Field: "age", Value: 18},
+				query.Equal{Field: "name", Value: "bob"},
+			}},
+			wantSQL:  `("age" = $1 AND "name" LIKE $2 ESCAPE '\')`,
+			wantArgs: []interface{}{18, "bob"},
+		},
+		{
+			name: "or shares a single, continuously numbered args slice",
+			pred: query.Predicate{query.Or{
+				query.Equal{Field: "age", Value: 18},
+				query.Equal{Field: "age", Value: 21},
+			}},
+			wantSQL:  `("age" = $1 OR "age" = $2)`,
+			wantArgs: []interface{}{18, 21},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := []interface{}{}
+			got, err := translatePredicate(tc.pred, &args)
+			if err != nil {
+				t.Fatalf("translatePredicate() error = %v", err)
+			}
+			if got != tc.wantSQL {
+				t.Errorf("translatePredicate() = %q, want %q", got, tc.wantSQL)
+			}
+			if !reflect.DeepEqual(args, tc.wantArgs) {
+				t.Errorf("translatePredicate() args = %#v, want %#v", args, tc.wantArgs)
+			}
+		})
+	}
+}
+
+func TestGetSort(t *testing.T) {
+	cases := []struct {
+		name string
+		q    *query.Query
+		want string
+	}{
+		{
+			name: "no sort falls back to id",
+			q:    &query.Query{},
+			want: `"id"`,
+		},
+		{
+			name: "ascending field is quoted",
+			q:    &query.Query{Sort: query.Sort{{Name: "name"}}},
+			want: `"name"`,
+		},
+		{
+			name: "reversed field appends DESC",
+			q:    &query.Query{Sort: query.Sort{{Name: "name", Reversed: true}}},
+			want: `"name" DESC`,
+		},
+		{
+			name: "multiple fields are comma separated",
+			q: &query.Query{Sort: query.Sort{
+				{Name: "name"},
+				{Name: "age", Reversed: true},
+			}},
+			want: `"name","age" DESC`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := getSort(tc.q); got != tc.want {
+				t.Errorf("getSort() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}